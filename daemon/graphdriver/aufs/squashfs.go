@@ -7,23 +7,59 @@ import (
 	"strings"
 )
 
-func (a *Driver) squashfsMount(name string) error {
-	source := path.Join(a.rootPath(), "squashfs", name)
-	layer := path.Join(a.rootPath(), "diff", name)
+// squashfsRoot returns the directory squashfs images are stored under, given
+// the driver's root directory.
+func squashfsRoot(rootPath string) string {
+	return path.Join(rootPath, "squashfs")
+}
 
-	if _, err := os.Stat(source); err == nil {
-		out, err := exec.Command("mount").Output()
-		if err != nil {
-			return err
-		}
+// squashfsDiffDir returns the diff directory a squashfs image for cacheID is
+// mounted in place of, given the driver's root directory.
+func squashfsDiffDir(rootPath, cacheID string) string {
+	return path.Join(rootPath, "diff", cacheID)
+}
+
+// SquashFSRoot implements graphdriver.SquashFSMounter.
+func (a *Driver) SquashFSRoot() string {
+	return squashfsRoot(a.rootPath())
+}
+
+// MountSquashFS implements graphdriver.SquashFSMounter by mounting the
+// squashfs file in place of the layer's diff directory.
+func (a *Driver) MountSquashFS(cacheID string) error {
+	source := path.Join(a.SquashFSRoot(), cacheID)
+	layer := squashfsDiffDir(a.rootPath(), cacheID)
+
+	if _, err := os.Stat(source); err != nil {
+		return nil
+	}
 
-		if !strings.Contains(string(out), layer) {
-			out, err = exec.Command("mount", "-t", "squashfs", source, layer).Output()
-			if err != nil {
-				return err
-			}
+	out, err := exec.Command("mount").Output()
+	if err != nil {
+		return err
+	}
+
+	if !strings.Contains(string(out), layer) {
+		if _, err := exec.Command("mount", "-t", "squashfs", source, layer).Output(); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
+
+// UnmountSquashFS implements graphdriver.SquashFSMounter.
+func (a *Driver) UnmountSquashFS(cacheID string) error {
+	layer := squashfsDiffDir(a.rootPath(), cacheID)
+
+	out, err := exec.Command("mount").Output()
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(out), layer) {
+		return nil
+	}
+
+	_, err = exec.Command("umount", layer).Output()
+	return err
+}
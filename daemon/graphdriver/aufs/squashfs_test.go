@@ -0,0 +1,20 @@
+package aufs
+
+import (
+	"path"
+	"testing"
+)
+
+func TestSquashfsRoot(t *testing.T) {
+	if got, want := squashfsRoot("/var/lib/docker/aufs"), path.Join("/var/lib/docker/aufs", "squashfs"); got != want {
+		t.Fatalf("squashfsRoot = %q, want %q", got, want)
+	}
+}
+
+func TestSquashfsDiffDir(t *testing.T) {
+	got := squashfsDiffDir("/var/lib/docker/aufs", "abc123")
+	want := path.Join("/var/lib/docker/aufs", "diff", "abc123")
+	if got != want {
+		t.Fatalf("squashfsDiffDir = %q, want %q", got, want)
+	}
+}
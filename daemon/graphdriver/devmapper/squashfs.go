@@ -0,0 +1,69 @@
+package devmapper
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// squashfsRoot returns the directory squashfs images are stored under, given
+// the driver's home directory.
+func squashfsRoot(home string) string {
+	return filepath.Join(home, "squashfs")
+}
+
+// squashfsSourcePath returns the on-disk location of the squashfs image for
+// id, given its storage root.
+func squashfsSourcePath(root, id string) string {
+	return filepath.Join(root, id)
+}
+
+// SquashFSRoot implements graphdriver.SquashFSMounter.
+func (d *Driver) SquashFSRoot() string {
+	return squashfsRoot(d.home)
+}
+
+// MountSquashFS implements graphdriver.SquashFSMounter. devicemapper layers
+// are normally backed by a thin device rather than a plain directory, so a
+// squashfs layer is mounted directly at the device's usual mount path
+// instead of being unpacked onto it.
+func (d *Driver) MountSquashFS(id string) error {
+	source := squashfsSourcePath(d.SquashFSRoot(), id)
+	target := d.DeviceSet.MountPath(id)
+
+	if _, err := os.Stat(source); err != nil {
+		return nil
+	}
+	if err := os.MkdirAll(target, 0700); err != nil {
+		return err
+	}
+
+	out, err := exec.Command("mount").Output()
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(out), target) {
+		if _, err := exec.Command("mount", "-t", "squashfs", "-o", "ro", source, target).Output(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UnmountSquashFS implements graphdriver.SquashFSMounter.
+func (d *Driver) UnmountSquashFS(id string) error {
+	target := d.DeviceSet.MountPath(id)
+
+	out, err := exec.Command("mount").Output()
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(out), target) {
+		return nil
+	}
+
+	_, err = exec.Command("umount", target).Output()
+	return err
+}
@@ -0,0 +1,21 @@
+package devmapper
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSquashfsRoot(t *testing.T) {
+	if got, want := squashfsRoot("/var/lib/docker/devicemapper"), filepath.Join("/var/lib/docker/devicemapper", "squashfs"); got != want {
+		t.Fatalf("squashfsRoot = %q, want %q", got, want)
+	}
+}
+
+func TestSquashfsSourcePath(t *testing.T) {
+	root := filepath.Join("/var/lib/docker/devicemapper", "squashfs")
+	got := squashfsSourcePath(root, "abc123")
+	want := filepath.Join(root, "abc123")
+	if got != want {
+		t.Fatalf("squashfsSourcePath = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,68 @@
+package overlay2
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// squashfsRoot returns the directory squashfs images are stored under, given
+// the driver's home directory.
+func squashfsRoot(home string) string {
+	return path.Join(home, "squashfs")
+}
+
+// squashfsLowerDir returns the read-only lower directory a squashfs image is
+// mounted at, given the layer's own directory (as returned by (*Driver).dir).
+func squashfsLowerDir(layerDir string) string {
+	return path.Join(layerDir, "lower-squashfs")
+}
+
+// SquashFSRoot implements graphdriver.SquashFSMounter.
+func (d *Driver) SquashFSRoot() string {
+	return squashfsRoot(d.home)
+}
+
+// MountSquashFS implements graphdriver.SquashFSMounter by mounting the
+// squashfs image for id as a read-only lower, ahead of the upper/work/merged
+// directories overlay2 normally assembles around a layer's diff.
+func (d *Driver) MountSquashFS(id string) error {
+	source := path.Join(d.SquashFSRoot(), id)
+	lower := squashfsLowerDir(d.dir(id))
+
+	if _, err := os.Stat(source); err != nil {
+		return nil
+	}
+	if err := os.MkdirAll(lower, 0700); err != nil {
+		return err
+	}
+
+	out, err := exec.Command("mount").Output()
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(out), lower) {
+		if _, err := exec.Command("mount", "-t", "squashfs", "-o", "ro", source, lower).Output(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UnmountSquashFS implements graphdriver.SquashFSMounter.
+func (d *Driver) UnmountSquashFS(id string) error {
+	lower := squashfsLowerDir(d.dir(id))
+
+	out, err := exec.Command("mount").Output()
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(out), lower) {
+		return nil
+	}
+
+	_, err = exec.Command("umount", lower).Output()
+	return err
+}
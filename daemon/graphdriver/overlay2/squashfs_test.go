@@ -0,0 +1,21 @@
+package overlay2
+
+import (
+	"path"
+	"testing"
+)
+
+func TestSquashfsRoot(t *testing.T) {
+	if got, want := squashfsRoot("/var/lib/docker/overlay2"), path.Join("/var/lib/docker/overlay2", "squashfs"); got != want {
+		t.Fatalf("squashfsRoot = %q, want %q", got, want)
+	}
+}
+
+func TestSquashfsLowerDir(t *testing.T) {
+	layerDir := path.Join("/var/lib/docker/overlay2", "abc123")
+	got := squashfsLowerDir(layerDir)
+	want := path.Join(layerDir, "lower-squashfs")
+	if got != want {
+		t.Fatalf("squashfsLowerDir = %q, want %q", got, want)
+	}
+}
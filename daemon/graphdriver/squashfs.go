@@ -0,0 +1,26 @@
+package graphdriver
+
+import "errors"
+
+// ErrSquashFSNotSupported is returned when a squashfs-based layer is
+// imported on a graph driver that does not implement SquashFSMounter.
+var ErrSquashFSNotSupported = errors.New("graphdriver: squashfs layers are not supported by the active graph driver")
+
+// SquashFSMounter is implemented by graph drivers that can mount a raw
+// squashfs image as a layer's diff directory, rather than unpacking it.
+// aufs mounts the squashfs file directly in place of the diff dir; overlay2
+// mounts it as the read-only lower before assembling the overlay; other
+// drivers may implement it however best fits their layout.
+type SquashFSMounter interface {
+	// SquashFSRoot returns the directory squashfs images for this driver
+	// are stored under.
+	SquashFSRoot() string
+
+	// MountSquashFS mounts the squashfs image stored under SquashFSRoot for
+	// cacheID, at the place the driver chooses (see above). It returns nil
+	// without mounting anything when no image is stored for cacheID.
+	MountSquashFS(cacheID string) error
+
+	// UnmountSquashFS reverses MountSquashFS.
+	UnmountSquashFS(cacheID string) error
+}
@@ -0,0 +1,126 @@
+package tarexport
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+// foreignLayerFetchRetries is the number of attempts made against each URL
+// of a foreign layer descriptor before moving on to the next one.
+const foreignLayerFetchRetries = 3
+
+// foreignLayerBackoff is the base delay between retries against a single
+// URL; it is doubled after each failed attempt.
+var foreignLayerBackoff = 500 * time.Millisecond
+
+// fetchForeignLayer streams a non-distributable layer blob referenced by
+// desc.URLs into a temp file under tmpDir, verifying its digest against
+// desc.Digest, and returns the path to the downloaded file. URLs are tried
+// in order, with retry/backoff against each before falling through to the
+// next, mirroring how foreign base layers are resolved during pull.
+func fetchForeignLayer(tmpDir string, desc distribution.Descriptor) (string, error) {
+	if len(desc.URLs) == 0 {
+		return "", fmt.Errorf("layer %s is not present in the archive and has no foreign URLs to fetch it from", desc.Digest)
+	}
+
+	f, err := ioutil.TempFile(tmpDir, "foreign-layer-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var lastErr error
+	for _, url := range desc.URLs {
+		if err := downloadWithRetry(f, url, desc.Digest); err != nil {
+			logrus.Warnf("failed to fetch foreign layer %s from %s: %v", desc.Digest, url, err)
+			lastErr = err
+			f.Seek(0, os.SEEK_SET)
+			f.Truncate(0)
+			continue
+		}
+		return f.Name(), nil
+	}
+
+	os.Remove(f.Name())
+	return "", fmt.Errorf("failed to fetch foreign layer %s from all %d source(s): %v", desc.Digest, len(desc.URLs), lastErr)
+}
+
+// downloadWithRetry attempts to download url into dst, verifying the result
+// against dgst, making up to foreignLayerFetchRetries attempts with
+// exponential backoff between them; any failure, including a digest
+// mismatch or an unexpected status, is retried.
+func downloadWithRetry(dst *os.File, url string, dgst digest.Digest) error {
+	var lastErr error
+	for attempt := 0; attempt < foreignLayerFetchRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(foreignLayerBackoff * time.Duration(1<<uint(attempt-1)))
+			dst.Seek(0, os.SEEK_SET)
+			dst.Truncate(0)
+		}
+
+		if err := download(dst, url, dgst); err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// download issues a GET against url (honoring the environment's
+// HTTP_PROXY/HTTPS_PROXY/NO_PROXY settings via http.ProxyFromEnvironment; no
+// daemon proxy or mirror configuration is consulted) and verifies the
+// response body against dgst while copying it to dst.
+func download(dst *os.File, url string, dgst digest.Digest) error {
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment},
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d fetching %s", resp.StatusCode, url)
+	}
+
+	verifier := dgst.Verifier()
+	if _, err := ioutil.ReadAll(&teeToFileReader{r: resp.Body, w: dst, h: verifier}); err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("digest mismatch fetching %s: expected %s", url, dgst)
+	}
+	return nil
+}
+
+// teeToFileReader copies everything read from r into both w and h, letting
+// the digest be verified in the same pass as the blob is persisted to disk.
+type teeToFileReader struct {
+	r io.Reader
+	w io.Writer
+	h io.Writer
+}
+
+func (t *teeToFileReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if _, werr := t.w.Write(p[:n]); werr != nil {
+			return n, werr
+		}
+		if _, herr := t.h.Write(p[:n]); herr != nil {
+			return n, herr
+		}
+	}
+	return n, err
+}
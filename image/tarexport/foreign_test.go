@@ -0,0 +1,95 @@
+package tarexport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestTeeToFileReaderCopiesToBothWriters(t *testing.T) {
+	src := []byte("layer blob content")
+
+	var fileBuf bytes.Buffer
+	h := digest.SHA256.Digester().Hash()
+	r := &teeToFileReader{r: bytes.NewReader(src), w: &fileBuf, h: h}
+
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, src) {
+		t.Fatalf("Read result = %q, want %q", got, src)
+	}
+	if !bytes.Equal(fileBuf.Bytes(), src) {
+		t.Fatalf("tee'd file content = %q, want %q", fileBuf.Bytes(), src)
+	}
+	if got, want := digest.NewDigest(digest.SHA256, h), digest.FromBytes(src); got != want {
+		t.Fatalf("tee'd digest = %s, want %s", got, want)
+	}
+}
+
+func TestFetchForeignLayerVerifiesDigest(t *testing.T) {
+	content := []byte("foreign layer bytes")
+	dgst := digest.FromBytes(content)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	tmpDir, err := ioutil.TempDir("", "foreign-layer-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	desc := distribution.Descriptor{Digest: dgst, URLs: []string{srv.URL}}
+	path, err := fetchForeignLayer(tmpDir, desc)
+	if err != nil {
+		t.Fatalf("fetchForeignLayer: %v", err)
+	}
+
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("fetched content = %q, want %q", got, content)
+	}
+}
+
+func TestFetchForeignLayerRejectsDigestMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("wrong content"))
+	}))
+	defer srv.Close()
+
+	tmpDir, err := ioutil.TempDir("", "foreign-layer-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	desc := distribution.Descriptor{Digest: digest.FromBytes([]byte("expected content")), URLs: []string{srv.URL}}
+	if _, err := fetchForeignLayer(tmpDir, desc); err == nil {
+		t.Fatal("expected digest mismatch error, got nil")
+	}
+}
+
+func TestFetchForeignLayerRequiresURLs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "foreign-layer-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if _, err := fetchForeignLayer(tmpDir, distribution.Descriptor{Digest: digest.FromBytes([]byte("x"))}); err == nil {
+		t.Fatal("expected error for descriptor with no URLs, got nil")
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -19,10 +18,10 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/daemon/graphdriver"
 	"github.com/docker/docker/image"
 	"github.com/docker/docker/image/v1"
 	"github.com/docker/docker/layer"
-	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/chrootarchive"
 	"github.com/docker/docker/pkg/progress"
 	"github.com/docker/docker/pkg/streamformatter"
@@ -34,6 +33,21 @@ import (
 )
 
 func (l *tarexporter) Load(inTar io.ReadCloser, outStream io.Writer, quiet bool) error {
+	return l.load(inTar, "", outStream, quiet)
+}
+
+// LoadSquashFSResumable behaves like Load, except that for a squashfs-format
+// stream it threads importID through to the chunked squashfs importer
+// instead of generating a random one. A caller that persists importID
+// across retries (e.g. an upload/session id tied to the client's request)
+// and sends the same stream again resumes an interrupted squashfs import:
+// chunks already written and verified under importID are not written again,
+// though the stream is still read in full; see importSquashFS.
+func (l *tarexporter) LoadSquashFSResumable(inTar io.ReadCloser, importID string, outStream io.Writer, quiet bool) error {
+	return l.load(inTar, importID, outStream, quiet)
+}
+
+func (l *tarexporter) load(inTar io.ReadCloser, importID string, outStream io.Writer, quiet bool) error {
 	var (
 		sf             = streamformatter.NewJSONStreamFormatter()
 		progressOutput progress.Output
@@ -60,13 +74,40 @@ func (l *tarexporter) Load(inTar io.ReadCloser, outStream io.Writer, quiet bool)
 
 	// squashfs magic
 	if bytes.Compare(b, []byte{0x68, 0x73, 0x71, 0x73}) == 0 {
-		return l.loadSquashFS(reader, tmpDir, outStream, progressOutput)
+		if importID == "" {
+			importID = uuid.New()
+		}
+		return l.loadSquashFS(reader, importID, tmpDir, outStream, progressOutput)
+	}
+
+	// on large tarballs, avoid the cost of untarring everything to tmpDir
+	// up front: a seekable input lets us scan the archive twice instead,
+	// keeping only the small json files in memory and streaming each
+	// layer blob straight into the layer store
+	if f, ok := inTar.(*os.File); ok {
+		handled, err := l.loadStreaming(f, outStream, progressOutput)
+		if err != nil {
+			return err
+		}
+		if handled {
+			return nil
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		reader = bufferedReadCloser{bufio.NewReader(f), f}
 	}
 
 	if err := chrootarchive.Untar(reader, tmpDir, nil); err != nil {
 		return err
 	}
 
+	// an OCI Image Layout is detected by the presence of the oci-layout
+	// marker file at the root of the extracted tar
+	if isOCILayout(tmpDir) {
+		return l.loadOCILayout(tmpDir, outStream, progressOutput)
+	}
+
 	// read manifest, if no file then load in legacy mode
 	manifestPath, err := safePath(tmpDir, manifestFileName)
 	if err != nil {
@@ -112,7 +153,7 @@ func (l *tarexporter) Load(inTar io.ReadCloser, outStream io.Writer, quiet bool)
 		}
 
 		for i, diffID := range img.RootFS.DiffIDs {
-			layerPath, err := safePath(tmpDir, m.Layers[i])
+			layerPath, err := l.resolveLayerPath(tmpDir, m.Layers[i], m.LayerSources[diffID])
 			if err != nil {
 				return err
 			}
@@ -186,32 +227,23 @@ func (l *tarexporter) hashOfFile(path string) (string, error) {
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func (l *tarexporter) loadSquashFS(inTar io.ReadCloser, tmpDir string, outStream io.Writer, progressOutput progress.Output) error {
-	// get storage root directory
-	root := ""
-	status := l.ls.Driver().Status()
-	for _, val := range status {
-		if val[0] == "Root Dir" {
-			root = val[1]
-		}
-	}
-	if root == "" {
-		return errors.New("cannot get root dir from graph driver")
+func (l *tarexporter) loadSquashFS(inTar io.ReadCloser, importID, tmpDir string, outStream io.Writer, progressOutput progress.Output) error {
+	mounter, ok := l.ls.Driver().(graphdriver.SquashFSMounter)
+	if !ok {
+		return graphdriver.ErrSquashFSNotSupported
 	}
+	// SquashFSRoot is the driver's <root>/squashfs directory; the import
+	// working directory and the final '<root>/squashfs/cacheID' path below
+	// are built from the driver root it sits in
+	root := path.Dir(mounter.SquashFSRoot())
 
-	// pipe input to destination file (will be renamed later on) and to sha256 hasher
-	tmpDestination := path.Join(root, "squashfs", fmt.Sprintf("import-%s", uuid.New()))
-	handle, err := os.Create(tmpDestination)
+	// chunk, hash and persist the incoming squashfs stream so that an
+	// interrupted import can resume without re-verifying what's already
+	// on disk; see importSquashFS for the resumable format
+	tmpDestination, hash, importDir, err := importSquashFS(root, importID, inTar)
 	if err != nil {
 		return err
 	}
-	defer handle.Close()
-
-	h := sha256.New()
-	if _, err := io.Copy(h, io.TeeReader(inTar, handle)); err != nil {
-		return err
-	}
-	hash := hex.EncodeToString(h.Sum(nil))
 
 	// create 'fake' tar archive
 	layerPath := path.Join(tmpDir, "content.tar")
@@ -278,7 +310,14 @@ func (l *tarexporter) loadSquashFS(inTar io.ReadCloser, tmpDir string, outStream
 	// rename file to '<root>/squashfs/cacheID'
 	destination := path.Join(root, "squashfs", cacheID)
 	outStream.Write([]byte(fmt.Sprintf("destination:%s\n", destination)))
-	os.Rename(tmpDestination, destination)
+	if err := syncRename(tmpDestination, destination); err != nil {
+		return err
+	}
+	// the data file has been moved out to its final cacheID path; drop the
+	// now-empty incoming/<importID> working directory and its manifest
+	if err := os.RemoveAll(importDir); err != nil {
+		logrus.Debugf("failed to clean up squashfs import dir %s: %v", importDir, err)
+	}
 
 	defer layer.ReleaseAndLog(l.ls, newLayer)
 
@@ -320,6 +359,26 @@ func (l *tarexporter) setParentID(id, parentID image.ID) error {
 	return l.is.SetParent(id, parentID)
 }
 
+// resolveLayerPath returns the on-disk path of a layer tar within tmpDir. If
+// layerRef is empty or does not exist in the archive, and src describes a
+// foreign (non-distributable) layer, the blob is fetched from one of its
+// URLs instead.
+func (l *tarexporter) resolveLayerPath(tmpDir, layerRef string, src distribution.Descriptor) (string, error) {
+	if layerRef != "" {
+		if layerPath, err := safePath(tmpDir, layerRef); err == nil {
+			if _, statErr := os.Stat(layerPath); statErr == nil {
+				return layerPath, nil
+			}
+		}
+	}
+
+	if len(src.URLs) == 0 {
+		return "", fmt.Errorf("invalid manifest, layer %q not found in archive", layerRef)
+	}
+
+	return fetchForeignLayer(tmpDir, src)
+}
+
 func (l *tarexporter) loadLayer(filename string, rootFS image.RootFS, id string, foreignSrc distribution.Descriptor, progressOutput progress.Output) (layer.Layer, error) {
 	// We use system.OpenSequential to use sequential file access on Windows, avoiding
 	// depleting the standby list. On Linux, this equates to a regular os.Open.
@@ -343,7 +402,7 @@ func (l *tarexporter) loadLayer(filename string, rootFS image.RootFS, id string,
 		r = rawTar
 	}
 
-	inflatedLayerData, err := archive.DecompressStream(r)
+	inflatedLayerData, err := decompressLayerStream(r)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,356 @@
+package tarexport
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/layer"
+	"github.com/docker/docker/pkg/progress"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	ociLayoutFileName = "oci-layout"
+	ociIndexFileName  = "index.json"
+	ociBlobsDir       = "blobs"
+)
+
+// ociLayoutMarker is the minimal structure of the `oci-layout` file used to
+// detect and validate an OCI Image Layout.
+type ociLayoutMarker struct {
+	Version string `json:"imageLayoutVersion"`
+}
+
+// isOCILayout reports whether tmpDir contains an OCI Image Layout, detected
+// by the presence of the oci-layout marker file at its root.
+func isOCILayout(tmpDir string) bool {
+	_, err := os.Stat(filepath.Join(tmpDir, ociLayoutFileName))
+	return err == nil
+}
+
+// loadOCILayout loads every image referenced by index.json of an OCI Image
+// Layout rooted at tmpDir, recursing into nested image indexes to support
+// multi-arch manifest lists.
+func (l *tarexporter) loadOCILayout(tmpDir string, outStream io.Writer, progressOutput progress.Output) error {
+	indexPath, err := safePath(tmpDir, ociIndexFileName)
+	if err != nil {
+		return err
+	}
+	indexData, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		return err
+	}
+	var index imagespec.Index
+	if err := json.Unmarshal(indexData, &index); err != nil {
+		return fmt.Errorf("invalid OCI index: %v", err)
+	}
+
+	var imageIDsStr string
+	imageRefCount := 0
+
+	descs, err := flattenOCIManifests(tmpDir, index.Manifests)
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range descs {
+		imgID, err := l.loadOCIManifest(tmpDir, desc, progressOutput)
+		if err != nil {
+			return err
+		}
+		imageIDsStr += fmt.Sprintf("Loaded image ID: %s\n", imgID)
+
+		if name, ok := desc.Annotations[imagespec.AnnotationRefName]; ok && name != "" {
+			named, err := reference.ParseNormalizedNamed(name)
+			if err != nil {
+				return err
+			}
+			ref, ok := named.(reference.NamedTagged)
+			if !ok {
+				return fmt.Errorf("invalid OCI ref annotation %q", name)
+			}
+			l.setLoadedTag(ref, imgID.Digest(), outStream)
+			outStream.Write([]byte(fmt.Sprintf("Loaded image: %s\n", reference.FamiliarString(ref))))
+			imageRefCount++
+		}
+
+		l.loggerImgEvent.LogImageEvent(imgID.String(), imgID.String(), "load")
+	}
+
+	if imageRefCount == 0 {
+		outStream.Write([]byte(imageIDsStr))
+	}
+
+	return nil
+}
+
+// flattenOCIManifests walks manifests, recursing into any nested image
+// indexes (multi-arch manifest lists) and returning the leaf image manifest
+// descriptors.
+func flattenOCIManifests(tmpDir string, manifests []imagespec.Descriptor) ([]imagespec.Descriptor, error) {
+	var out []imagespec.Descriptor
+	for _, desc := range manifests {
+		switch desc.MediaType {
+		case imagespec.MediaTypeImageIndex:
+			data, err := readOCIBlob(tmpDir, desc)
+			if err != nil {
+				return nil, err
+			}
+			var nested imagespec.Index
+			if err := json.Unmarshal(data, &nested); err != nil {
+				return nil, fmt.Errorf("invalid nested OCI index %s: %v", desc.Digest, err)
+			}
+			children, err := flattenOCIManifests(tmpDir, nested.Manifests)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, children...)
+		case imagespec.MediaTypeImageManifest:
+			out = append(out, desc)
+		default:
+			return nil, fmt.Errorf("unsupported OCI manifest media type %q", desc.MediaType)
+		}
+	}
+	return out, nil
+}
+
+// loadOCIManifest resolves the config and layers referenced by an OCI image
+// manifest descriptor, registers the layers with the layer store and creates
+// the resulting image.
+func (l *tarexporter) loadOCIManifest(tmpDir string, desc imagespec.Descriptor, progressOutput progress.Output) (image.ID, error) {
+	manifestData, err := readOCIBlob(tmpDir, desc)
+	if err != nil {
+		return "", err
+	}
+	var manifest imagespec.Manifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return "", fmt.Errorf("invalid OCI manifest %s: %v", desc.Digest, err)
+	}
+
+	config, err := readOCIBlob(tmpDir, manifest.Config)
+	if err != nil {
+		return "", err
+	}
+	img, err := image.NewFromJSON(config)
+	if err != nil {
+		return "", err
+	}
+
+	var rootFS image.RootFS
+	rootFS = *img.RootFS
+	rootFS.DiffIDs = nil
+
+	if expected, actual := len(manifest.Layers), len(img.RootFS.DiffIDs); expected != actual {
+		return "", fmt.Errorf("invalid OCI manifest, layers length mismatch: expected %d, got %d", expected, actual)
+	}
+
+	for i, layerDesc := range manifest.Layers {
+		diffID := img.RootFS.DiffIDs[i]
+		r := rootFS
+		r.Append(diffID)
+
+		newLayer, err := l.ls.Get(r.ChainID())
+		if err != nil {
+			layerPath, err := ociBlobPath(tmpDir, layerDesc.Digest)
+			if err != nil {
+				return "", err
+			}
+			if err := verifyOCIBlobDigest(layerPath, layerDesc.Digest); err != nil {
+				return "", err
+			}
+			newLayer, err = l.loadOCILayer(layerPath, layerDesc, rootFS, progressOutput)
+			if err != nil {
+				return "", err
+			}
+		}
+		defer layer.ReleaseAndLog(l.ls, newLayer)
+		if expected, actual := diffID, newLayer.DiffID(); expected != actual {
+			return "", fmt.Errorf("invalid diffID for layer %d: expected %q, got %q", i, expected, actual)
+		}
+		rootFS.Append(diffID)
+	}
+
+	return l.is.Create(config)
+}
+
+// loadOCILayer registers a single OCI layer blob with the layer store,
+// selecting the decompression path appropriate for its declared media type.
+func (l *tarexporter) loadOCILayer(layerPath string, desc imagespec.Descriptor, rootFS image.RootFS, progressOutput progress.Output) (layer.Layer, error) {
+	switch desc.MediaType {
+	case imagespec.MediaTypeImageLayer,
+		imagespec.MediaTypeImageLayerGzip,
+		imagespec.MediaTypeImageLayerZstd,
+		imagespec.MediaTypeImageLayerNonDistributable,
+		imagespec.MediaTypeImageLayerNonDistributableGzip,
+		imagespec.MediaTypeImageLayerNonDistributableZstd:
+		return l.loadLayer(layerPath, rootFS, desc.Digest.Encoded(), distribution.Descriptor{URLs: desc.URLs, Digest: desc.Digest, MediaType: string(desc.MediaType)}, progressOutput)
+	default:
+		return nil, fmt.Errorf("unsupported OCI layer media type %q", desc.MediaType)
+	}
+}
+
+// readOCIBlob reads and digest-verifies a blob referenced by an OCI
+// descriptor from <tmpDir>/blobs/<algo>/<encoded>.
+func readOCIBlob(tmpDir string, desc imagespec.Descriptor) ([]byte, error) {
+	blobPath, err := ociBlobPath(tmpDir, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyOCIBlobDigest(blobPath, desc.Digest); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(blobPath)
+}
+
+// ociBlobPath resolves the on-disk location of a content-addressed blob
+// within an OCI Image Layout.
+func ociBlobPath(tmpDir string, dgst digest.Digest) (string, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", err
+	}
+	return safePath(tmpDir, filepath.Join(ociBlobsDir, dgst.Algorithm().String(), dgst.Encoded()))
+}
+
+// verifyOCIBlobDigest verifies that the blob on disk at path matches dgst
+// before it is trusted and registered with the layer store.
+func verifyOCIBlobDigest(path string, dgst digest.Digest) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	verifier := dgst.Verifier()
+	if _, err := io.Copy(verifier, f); err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("blob digest mismatch: expected %s", dgst)
+	}
+	return nil
+}
+
+// ociLayoutWriter accumulates blobs and manifests into an OCI Image Layout
+// directory, deduplicating blobs shared across multiple images by digest.
+type ociLayoutWriter struct {
+	root      string
+	manifests []imagespec.Descriptor
+	written   map[digest.Digest]struct{}
+}
+
+func newOCILayoutWriter(root string) (*ociLayoutWriter, error) {
+	for _, algo := range []digest.Algorithm{digest.SHA256} {
+		if err := os.MkdirAll(filepath.Join(root, ociBlobsDir, algo.String()), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &ociLayoutWriter{root: root, written: make(map[digest.Digest]struct{})}, nil
+}
+
+// putBlob writes data under blobs/<algo>/<encoded> if it isn't already
+// present, and returns its descriptor.
+func (w *ociLayoutWriter) putBlob(data []byte, mediaType string) (imagespec.Descriptor, error) {
+	dgst := digest.FromBytes(data)
+	if _, ok := w.written[dgst]; !ok {
+		blobPath, err := ociBlobPath(w.root, dgst)
+		if err != nil {
+			return imagespec.Descriptor{}, err
+		}
+		if _, err := os.Stat(blobPath); err != nil {
+			if err := ioutil.WriteFile(blobPath, data, 0644); err != nil {
+				return imagespec.Descriptor{}, err
+			}
+		}
+		w.written[dgst] = struct{}{}
+	}
+	return imagespec.Descriptor{
+		MediaType: mediaType,
+		Digest:    dgst,
+		Size:      int64(len(data)),
+	}, nil
+}
+
+// putBlobStream streams r to a blob named by its own digest, so large blobs
+// such as layers never need to be buffered in memory. It writes to a temp
+// file under root first and renames into place, so a concurrent or repeated
+// Save of the same content is a no-op once the digest is already present.
+func (w *ociLayoutWriter) putBlobStream(r io.Reader, mediaType string) (imagespec.Descriptor, error) {
+	tmp, err := ioutil.TempFile(w.root, "blob-")
+	if err != nil {
+		return imagespec.Descriptor{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		tmp.Close()
+		return imagespec.Descriptor{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return imagespec.Descriptor{}, err
+	}
+
+	dgst := digest.NewDigest(digest.SHA256, h)
+	if _, ok := w.written[dgst]; !ok {
+		blobPath, err := ociBlobPath(w.root, dgst)
+		if err != nil {
+			return imagespec.Descriptor{}, err
+		}
+		if _, err := os.Stat(blobPath); err != nil {
+			if err := os.Rename(tmp.Name(), blobPath); err != nil {
+				return imagespec.Descriptor{}, err
+			}
+		}
+		w.written[dgst] = struct{}{}
+	}
+
+	return imagespec.Descriptor{
+		MediaType: mediaType,
+		Digest:    dgst,
+		Size:      size,
+	}, nil
+}
+
+// addManifest registers a top-level image manifest descriptor, optionally
+// tagged via the OCI ref-name annotation.
+func (w *ociLayoutWriter) addManifest(desc imagespec.Descriptor, refName string) {
+	if refName != "" {
+		if desc.Annotations == nil {
+			desc.Annotations = make(map[string]string)
+		}
+		desc.Annotations[imagespec.AnnotationRefName] = refName
+	}
+	w.manifests = append(w.manifests, desc)
+}
+
+// close writes oci-layout and index.json, finalizing the layout.
+func (w *ociLayoutWriter) close() error {
+	marker, err := json.Marshal(ociLayoutMarker{Version: "1.0.0"})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(w.root, ociLayoutFileName), marker, 0644); err != nil {
+		return err
+	}
+
+	index := imagespec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: w.manifests,
+	}
+	indexData, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(w.root, ociIndexFileName), indexData, 0644)
+}
@@ -0,0 +1,137 @@
+package tarexport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestIsOCILayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-layout-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if isOCILayout(dir) {
+		t.Fatal("expected isOCILayout to be false without an oci-layout marker")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ociLayoutFileName), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !isOCILayout(dir) {
+		t.Fatal("expected isOCILayout to be true once the oci-layout marker exists")
+	}
+}
+
+func TestOCILayoutWriterPutBlobDeduplicates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-layout-writer-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := newOCILayoutWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte(`{"hello":"world"}`)
+	d1, err := w.putBlob(data, imagespec.MediaTypeImageConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d2, err := w.putBlob(data, imagespec.MediaTypeImageConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d1.Digest != d2.Digest {
+		t.Fatalf("identical content produced different digests: %s vs %s", d1.Digest, d2.Digest)
+	}
+
+	blobPath, err := ociBlobPath(dir, d1.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(blobPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Fatalf("blob content = %q, want %q", got, data)
+	}
+}
+
+func TestOCILayoutWriterPutBlobStreamMatchesPutBlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-layout-writer-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := newOCILayoutWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("some layer bytes")
+	want, err := w.putBlob(data, imagespec.MediaTypeImageLayerGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir2, err := ioutil.TempDir("", "oci-layout-writer-test-2-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir2)
+	w2, err := newOCILayoutWriter(dir2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := w2.putBlobStream(bytes.NewReader(data), imagespec.MediaTypeImageLayerGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Digest != want.Digest || got.Size != want.Size {
+		t.Fatalf("putBlobStream descriptor = %+v, want digest/size matching putBlob's %+v", got, want)
+	}
+}
+
+func TestVerifyOCIBlobDigestDetectsCorruption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-blob-verify-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := []byte("trustworthy bytes")
+	dgst := digest.FromBytes(data)
+	blobPath, err := ociBlobPath(dir, dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(blobPath, []byte("corrupted bytes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifyOCIBlobDigest(blobPath, dgst); err == nil {
+		t.Fatal("expected digest verification to fail for corrupted blob")
+	}
+
+	if err := ioutil.WriteFile(blobPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyOCIBlobDigest(blobPath, dgst); err != nil {
+		t.Fatalf("verifyOCIBlobDigest: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package tarexport
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/layer"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/opencontainers/image-spec/specs-go"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// SaveOCI writes the named images to outStream as an OCI Image Layout
+// tarball: an oci-layout marker, an index.json referencing one manifest per
+// name, and the referenced config/layer blobs under blobs/sha256/*. Layers
+// shared between the given images are compressed and written only once.
+func (l *tarexporter) SaveOCI(names []string, outStream io.Writer) error {
+	tmpDir, err := ioutil.TempDir("", "docker-export-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	w, err := newOCILayoutWriter(tmpDir)
+	if err != nil {
+		return err
+	}
+
+	layerDescCache := make(map[layer.ChainID]imagespec.Descriptor)
+
+	for _, name := range names {
+		named, err := reference.ParseNormalizedNamed(name)
+		if err != nil {
+			return err
+		}
+		tagged, ok := named.(reference.NamedTagged)
+		if !ok {
+			return fmt.Errorf("invalid reference format, %q must include a tag", name)
+		}
+
+		dgst, err := l.rs.Get(tagged)
+		if err != nil {
+			return err
+		}
+		img, err := l.is.Get(image.IDFromDigest(dgst))
+		if err != nil {
+			return err
+		}
+
+		manifestDesc, err := l.saveImageOCIManifest(w, img, layerDescCache)
+		if err != nil {
+			return err
+		}
+		w.addManifest(manifestDesc, reference.FamiliarString(tagged))
+	}
+
+	if err := w.close(); err != nil {
+		return err
+	}
+
+	rc, err := archive.Tar(tmpDir, archive.Uncompressed)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(outStream, rc)
+	return err
+}
+
+// saveImageOCIManifest writes img's config and layer blobs, returning a
+// descriptor for its OCI image manifest. Layers already seen via
+// layerDescCache (shared with a previously saved image) are not
+// recompressed.
+func (l *tarexporter) saveImageOCIManifest(w *ociLayoutWriter, img *image.Image, layerDescCache map[layer.ChainID]imagespec.Descriptor) (imagespec.Descriptor, error) {
+	configDesc, err := w.putBlob(img.RawJSON(), imagespec.MediaTypeImageConfig)
+	if err != nil {
+		return imagespec.Descriptor{}, err
+	}
+
+	var rootFS image.RootFS
+	rootFS = *img.RootFS
+	rootFS.DiffIDs = nil
+
+	layerDescs := make([]imagespec.Descriptor, 0, len(img.RootFS.DiffIDs))
+	for _, diffID := range img.RootFS.DiffIDs {
+		rootFS.Append(diffID)
+		chainID := rootFS.ChainID()
+
+		desc, ok := layerDescCache[chainID]
+		if !ok {
+			lyr, err := l.ls.Get(chainID)
+			if err != nil {
+				return imagespec.Descriptor{}, err
+			}
+			desc, err = saveLayerBlob(w, lyr)
+			layer.ReleaseAndLog(l.ls, lyr)
+			if err != nil {
+				return imagespec.Descriptor{}, err
+			}
+			layerDescCache[chainID] = desc
+		}
+		layerDescs = append(layerDescs, desc)
+	}
+
+	manifest := imagespec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Config:    configDesc,
+		Layers:    layerDescs,
+	}
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return imagespec.Descriptor{}, err
+	}
+	return w.putBlob(manifestData, imagespec.MediaTypeImageManifest)
+}
+
+// saveLayerBlob gzip-compresses a single layer's diff and writes it as a
+// content-addressed blob.
+func saveLayerBlob(w *ociLayoutWriter, lyr layer.Layer) (imagespec.Descriptor, error) {
+	diff, err := lyr.TarStream()
+	if err != nil {
+		return imagespec.Descriptor{}, err
+	}
+	defer diff.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(pw)
+		if _, err := io.Copy(gz, diff); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if err := gz.Close(); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.Close()
+	}()
+
+	return w.putBlobStream(pr, imagespec.MediaTypeImageLayerGzip)
+}
@@ -0,0 +1,222 @@
+package tarexport
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// squashfsChunkSize is the unit in which an incoming squashfs stream is
+// hashed and persisted, so an interrupted import can resume without
+// rewriting chunks that were already written and verified; the stream itself
+// is still read and hashed in full on every attempt.
+const squashfsChunkSize = 4 * 1024 * 1024
+
+// squashfsChunkEntry records the verified placement of a single chunk of an
+// in-progress squashfs import.
+type squashfsChunkEntry struct {
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// squashfsImportManifest is persisted alongside the partially-written
+// squashfs data so a resumed import can tell which prefix of the stream is
+// already verified on disk.
+type squashfsImportManifest struct {
+	Chunks []squashfsChunkEntry `json:"chunks"`
+}
+
+// importIDPattern restricts the caller-supplied import session id to safe
+// path-component characters, since it becomes a directory name under root.
+var importIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,128}$`)
+
+// importSquashFS reads inTar in squashfsChunkSize chunks, hashing and
+// persisting each one under <root>/squashfs/incoming/<importID>/data.
+// importID identifies the logical import across retries - it must be
+// supplied by the caller (e.g. an API-level upload/session id) rather than
+// derived from the stream's own content, since two unrelated streams can
+// otherwise share a prefix and collide onto the same working directory. A
+// manifest of per-chunk offsets/sizes/digests is kept next to the data
+// file; chunks already present and verified there are skipped on resume. It
+// returns the path to the fully-written data file, the sha256 of the whole
+// stream, and the import directory so the caller can remove it once the
+// data file has been moved to its final destination.
+func importSquashFS(root, importID string, inTar io.Reader) (dataPath, hash, importDir string, err error) {
+	if !importIDPattern.MatchString(importID) {
+		return "", "", "", fmt.Errorf("invalid squashfs import id %q", importID)
+	}
+
+	incomingRoot := filepath.Join(root, "squashfs", "incoming")
+	if err := os.MkdirAll(incomingRoot, 0700); err != nil {
+		return "", "", "", err
+	}
+
+	importDir = filepath.Join(incomingRoot, importID)
+	if err := os.MkdirAll(importDir, 0700); err != nil {
+		return "", "", "", err
+	}
+	manifestPath := filepath.Join(importDir, "manifest.json")
+	dataPath = filepath.Join(importDir, "data")
+	manifest := loadSquashfsManifest(manifestPath)
+
+	rolling := sha256.New()
+	buf := make([]byte, squashfsChunkSize)
+	var offset int64
+
+	for chunkIndex := 0; ; chunkIndex++ {
+		n, readErr := io.ReadFull(inTar, buf)
+		if n == 0 {
+			if readErr == io.EOF {
+				break
+			}
+			if readErr != nil {
+				return "", "", "", readErr
+			}
+		}
+		chunk := buf[:n]
+		sum := sha256.Sum256(chunk)
+		chunkDigest := hex.EncodeToString(sum[:])
+
+		if existing, ok := verifiedChunk(manifest, chunkIndex, offset, int64(n), chunkDigest, dataPath); ok {
+			logrus.Debugf("squashfs import: resuming, chunk %d already verified at offset %d", chunkIndex, existing.Offset)
+		} else {
+			if err := writeChunk(dataPath, offset, chunk); err != nil {
+				return "", "", "", err
+			}
+			manifest = appendChunk(manifest, chunkIndex, squashfsChunkEntry{Offset: offset, Size: int64(n), SHA256: chunkDigest})
+			if err := saveSquashfsManifest(manifestPath, manifest); err != nil {
+				return "", "", "", err
+			}
+		}
+
+		rolling.Write(chunk)
+		offset += int64(n)
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return "", "", "", readErr
+		}
+	}
+
+	// a reused importID whose new stream is shorter than a previous attempt
+	// under the same id would otherwise leave that attempt's trailing bytes
+	// in place past offset, corrupting the file this digest describes.
+	f, err := os.OpenFile(dataPath, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", "", "", err
+	}
+	truncErr := f.Truncate(offset)
+	closeErr := f.Close()
+	if truncErr != nil {
+		return "", "", "", truncErr
+	}
+	if closeErr != nil {
+		return "", "", "", closeErr
+	}
+
+	return dataPath, hex.EncodeToString(rolling.Sum(nil)), importDir, nil
+}
+
+func loadSquashfsManifest(path string) squashfsImportManifest {
+	var manifest squashfsImportManifest
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return manifest
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return squashfsImportManifest{}
+	}
+	return manifest
+}
+
+func saveSquashfsManifest(path string, manifest squashfsImportManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// verifiedChunk reports whether the manifest already records chunkIndex at
+// the expected offset/size/digest, and that the corresponding bytes are
+// actually present on disk (protecting against a manifest that outran a
+// crash mid-write).
+func verifiedChunk(manifest squashfsImportManifest, chunkIndex int, offset, size int64, digest, dataPath string) (squashfsChunkEntry, bool) {
+	if chunkIndex >= len(manifest.Chunks) {
+		return squashfsChunkEntry{}, false
+	}
+	entry := manifest.Chunks[chunkIndex]
+	if entry.Offset != offset || entry.Size != size || entry.SHA256 != digest {
+		return squashfsChunkEntry{}, false
+	}
+	fi, err := os.Stat(dataPath)
+	if err != nil || fi.Size() < offset+size {
+		return squashfsChunkEntry{}, false
+	}
+	return entry, true
+}
+
+func appendChunk(manifest squashfsImportManifest, chunkIndex int, entry squashfsChunkEntry) squashfsImportManifest {
+	if chunkIndex < len(manifest.Chunks) {
+		manifest.Chunks[chunkIndex] = entry
+		return manifest
+	}
+	manifest.Chunks = append(manifest.Chunks, entry)
+	return manifest
+}
+
+func writeChunk(dataPath string, offset int64, chunk []byte) error {
+	f, err := os.OpenFile(dataPath, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteAt(chunk, offset); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// syncRename moves src to dst, fsyncing the file before the rename and the
+// containing directory afterwards, so that a crash cannot leave a
+// half-written squashfs file visible at dst's final name.
+func syncRename(src, dst string) error {
+	f, err := os.OpenFile(src, os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(dst))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
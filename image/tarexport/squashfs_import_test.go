@@ -0,0 +1,206 @@
+package tarexport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportSquashFSWritesAllChunks(t *testing.T) {
+	root, err := ioutil.TempDir("", "squashfs-import-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	data := bytes.Repeat([]byte{0x42}, squashfsChunkSize*2+123)
+
+	dataPath, hash, importDir, err := importSquashFS(root, "session1", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("importSquashFS: %v", err)
+	}
+	defer os.RemoveAll(importDir)
+
+	got, err := ioutil.ReadFile(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("data file content mismatch: got %d bytes, want %d", len(got), len(data))
+	}
+
+	_, wantHash, _, err := importSquashFS(root, "session2", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != wantHash {
+		t.Fatalf("hash = %q, want %q", hash, wantHash)
+	}
+}
+
+func TestImportSquashFSRejectsInvalidImportID(t *testing.T) {
+	root, err := ioutil.TempDir("", "squashfs-import-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if _, _, _, err := importSquashFS(root, "../escape", bytes.NewReader(nil)); err == nil {
+		t.Fatal("expected error for path-traversal import id, got nil")
+	}
+	if _, _, _, err := importSquashFS(root, "", bytes.NewReader(nil)); err == nil {
+		t.Fatal("expected error for empty import id, got nil")
+	}
+}
+
+// TestImportSquashFSResumesWithoutRewritingVerifiedChunks simulates a crash
+// after the first chunk has been written, checks that the recorded chunk is
+// recognized as already verified (so a second call with the same importID
+// skips rewriting it), and that the second call still produces the complete,
+// correct file.
+func TestImportSquashFSResumesWithoutRewritingVerifiedChunks(t *testing.T) {
+	root, err := ioutil.TempDir("", "squashfs-import-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	chunk0 := bytes.Repeat([]byte{0x01}, squashfsChunkSize)
+	chunk1 := bytes.Repeat([]byte{0x02}, 512)
+	full := append(append([]byte{}, chunk0...), chunk1...)
+
+	// first attempt only sees the first chunk, simulating an interrupted
+	// stream.
+	if _, _, importDir, err := importSquashFS(root, "resume-session", bytes.NewReader(chunk0)); err != nil {
+		t.Fatal(err)
+	} else {
+		defer os.RemoveAll(importDir)
+	}
+
+	manifestPath := filepath.Join(root, "squashfs", "incoming", "resume-session", "manifest.json")
+	before := loadSquashfsManifest(manifestPath)
+	if len(before.Chunks) != 1 {
+		t.Fatalf("expected 1 chunk recorded after first attempt, got %d", len(before.Chunks))
+	}
+	sum0 := sha256.Sum256(chunk0)
+	dataPath0 := filepath.Join(root, "squashfs", "incoming", "resume-session", "data")
+	if _, ok := verifiedChunk(before, 0, 0, squashfsChunkSize, hex.EncodeToString(sum0[:]), dataPath0); !ok {
+		t.Fatal("expected the first attempt's chunk to be recognized as verified, so a resume skips rewriting it")
+	}
+
+	dataPath, hash, _, err := importSquashFS(root, "resume-session", bytes.NewReader(full))
+	if err != nil {
+		t.Fatalf("resumed importSquashFS: %v", err)
+	}
+
+	got, err := ioutil.ReadFile(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, full) {
+		t.Fatalf("resumed data file mismatch: got %d bytes, want %d", len(got), len(full))
+	}
+
+	_, wantHash, importDir2, err := importSquashFS(root, "resume-verify", bytes.NewReader(full))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(importDir2)
+	if hash != wantHash {
+		t.Fatalf("resumed hash = %q, want %q", hash, wantHash)
+	}
+}
+
+// TestImportSquashFSTruncatesStaleTrailingBytes covers reusing an importID
+// for a shorter stream than a previous attempt under the same id: bytes left
+// over past the new stream's end must not survive in the returned data file.
+func TestImportSquashFSTruncatesStaleTrailingBytes(t *testing.T) {
+	root, err := ioutil.TempDir("", "squashfs-import-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	long := bytes.Repeat([]byte{0x03}, squashfsChunkSize+1000)
+	short := bytes.Repeat([]byte{0x04}, 100)
+
+	if _, _, importDir, err := importSquashFS(root, "reused-session", bytes.NewReader(long)); err != nil {
+		t.Fatal(err)
+	} else {
+		defer os.RemoveAll(importDir)
+	}
+
+	dataPath, hash, _, err := importSquashFS(root, "reused-session", bytes.NewReader(short))
+	if err != nil {
+		t.Fatalf("importSquashFS: %v", err)
+	}
+
+	got, err := ioutil.ReadFile(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, short) {
+		t.Fatalf("data file = %d bytes, want the %d-byte short stream with no stale trailing bytes", len(got), len(short))
+	}
+
+	_, wantHash, importDir2, err := importSquashFS(root, "reused-verify", bytes.NewReader(short))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(importDir2)
+	if hash != wantHash {
+		t.Fatalf("hash = %q, want %q (digest must match the truncated file's actual content)", hash, wantHash)
+	}
+}
+
+func TestVerifiedChunkRejectsTruncatedData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "squashfs-verify-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dataPath := filepath.Join(dir, "data")
+	if err := ioutil.WriteFile(dataPath, []byte("short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := squashfsImportManifest{Chunks: []squashfsChunkEntry{
+		{Offset: 0, Size: 100, SHA256: "deadbeef"},
+	}}
+	if _, ok := verifiedChunk(manifest, 0, 0, 100, "deadbeef", dataPath); ok {
+		t.Fatal("expected verifiedChunk to reject an entry whose bytes aren't fully on disk")
+	}
+}
+
+func TestSyncRenameMovesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "squashfs-syncrename-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	if err := ioutil.WriteFile(src, []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := syncRename(src, dst); err != nil {
+		t.Fatalf("syncRename: %v", err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Fatal("expected src to no longer exist after rename")
+	}
+	got, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("dst content = %q, want %q", got, "hello")
+	}
+}
@@ -0,0 +1,334 @@
+package tarexport
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/layer"
+	"github.com/docker/docker/pkg/progress"
+	"github.com/docker/docker/pkg/stringid"
+)
+
+// pendingLayer is a layer a manifest entry needs registered, resolved from
+// its tar path to a concrete parent chain/diffID ahead of the streaming
+// scan. The same path can have more than one pendingLayer when several
+// images in the archive share a non-root layer.
+type pendingLayer struct {
+	path    string
+	rootFS  image.RootFS
+	diffID  layer.DiffID
+	source  distribution.Descriptor
+	chainID layer.ChainID
+}
+
+// loadStreaming attempts to load a Docker-format image tar directly from a
+// seekable *os.File without first unpacking it to a temp directory. It scans
+// the tar twice: once to collect manifest.json and the small *.json config
+// blobs into memory, and once to pipe each referenced layer blob straight
+// into the layer store as it is encountered. A layer blob is written to a
+// temp file only when more than one parent chain needs it registered (see
+// spoolTarEntry). It returns handled=false without error when the tar
+// isn't a manifest.json-based export (the legacy per-directory format, or an
+// OCI layout), so the caller can fall back to the existing untar-to-tmpdir
+// path.
+func (l *tarexporter) loadStreaming(f *os.File, outStream io.Writer, progressOutput progress.Output) (handled bool, err error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	manifest, jsonFiles, found, err := scanTarMetadata(f)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		return false, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return true, err
+	}
+
+	type imageWork struct {
+		m      manifestItem
+		img    *image.Image
+		config []byte
+	}
+
+	pending := make(map[string][]*pendingLayer)
+	var allRefs []*pendingLayer
+	var images []*imageWork
+
+	for _, m := range manifest {
+		config, ok := jsonFiles[m.Config]
+		if !ok {
+			return true, fmt.Errorf("invalid manifest, config %q not found in archive", m.Config)
+		}
+		img, err := image.NewFromJSON(config)
+		if err != nil {
+			return true, err
+		}
+		if expected, actual := len(m.Layers), len(img.RootFS.DiffIDs); expected != actual {
+			return true, fmt.Errorf("invalid manifest, layers length mismatch: expected %d, got %d", expected, actual)
+		}
+
+		var rootFS image.RootFS
+		rootFS = *img.RootFS
+		rootFS.DiffIDs = nil
+
+		for i, diffID := range img.RootFS.DiffIDs {
+			chainBefore := rootFS
+			chainBefore.Append(diffID)
+			ref := &pendingLayer{
+				path:    m.Layers[i],
+				rootFS:  rootFS,
+				diffID:  diffID,
+				source:  m.LayerSources[diffID],
+				chainID: chainBefore.ChainID(),
+			}
+			pending[m.Layers[i]] = append(pending[m.Layers[i]], ref)
+			allRefs = append(allRefs, ref)
+			rootFS.Append(diffID)
+		}
+
+		images = append(images, &imageWork{m: m, img: img, config: config})
+	}
+
+	var registeredLayers []layer.Layer
+	defer func() {
+		for _, newLayer := range registeredLayers {
+			layer.ReleaseAndLog(l.ls, newLayer)
+		}
+	}()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, terr := tr.Next()
+		if terr == io.EOF {
+			break
+		}
+		if terr != nil {
+			return true, terr
+		}
+
+		refs, ok := pending[strings.TrimPrefix(hdr.Name, "./")]
+		if !ok || len(refs) == 0 {
+			continue
+		}
+
+		unregistered := refs[:0:0]
+		for _, ref := range refs {
+			if existing, err := l.ls.Get(ref.chainID); err == nil {
+				registeredLayers = append(registeredLayers, existing)
+				continue
+			}
+			unregistered = append(unregistered, ref)
+		}
+		if len(unregistered) == 0 {
+			continue
+		}
+
+		if len(unregistered) == 1 {
+			newLayer, err := l.registerLayerFromReader(tr, hdr.Size, unregistered[0], progressOutput)
+			if err != nil {
+				return true, err
+			}
+			registeredLayers = append(registeredLayers, newLayer)
+			continue
+		}
+
+		// more than one image needs this physical blob registered under a
+		// distinct parent chain (e.g. `docker save img1 img2` where img1
+		// and img2 share a non-root layer but manifest.json legitimately
+		// reuses the same path for both) - spool it once so each chain can
+		// be decompressed and registered from its own reader
+		spoolPath, err := spoolTarEntry(tr)
+		if err != nil {
+			return true, err
+		}
+		for _, ref := range unregistered {
+			newLayer, err := func() (layer.Layer, error) {
+				sf, err := os.Open(spoolPath)
+				if err != nil {
+					return nil, err
+				}
+				defer sf.Close()
+				return l.registerLayerFromReader(sf, hdr.Size, ref, progressOutput)
+			}()
+			if err != nil {
+				os.Remove(spoolPath)
+				return true, err
+			}
+			registeredLayers = append(registeredLayers, newLayer)
+		}
+		os.Remove(spoolPath)
+	}
+
+	// anything still missing never showed up in the archive at all: either
+	// it's a foreign/non-distributable layer that's expected to be fetched
+	// from the source's URLs, or the archive is truncated/mismatched with
+	// its own manifest
+	var foreignTmpDir string
+	for _, ref := range allRefs {
+		if existing, err := l.ls.Get(ref.chainID); err == nil {
+			registeredLayers = append(registeredLayers, existing)
+			continue
+		}
+		if len(ref.source.URLs) == 0 {
+			return true, fmt.Errorf("invalid manifest, layer %q not found in archive", ref.path)
+		}
+		if foreignTmpDir == "" {
+			foreignTmpDir, err = ioutil.TempDir("", "docker-import-foreign-")
+			if err != nil {
+				return true, err
+			}
+			defer os.RemoveAll(foreignTmpDir)
+		}
+		layerPath, err := fetchForeignLayer(foreignTmpDir, ref.source)
+		if err != nil {
+			return true, err
+		}
+		newLayer, err := l.loadLayer(layerPath, ref.rootFS, ref.diffID.String(), ref.source, progressOutput)
+		if err != nil {
+			return true, err
+		}
+		registeredLayers = append(registeredLayers, newLayer)
+	}
+
+	var parentLinks []parentLink
+	var imageIDsStr string
+	imageRefCount := 0
+
+	for _, w := range images {
+		imgID, err := l.is.Create(w.config)
+		if err != nil {
+			return true, err
+		}
+		imageIDsStr += fmt.Sprintf("Loaded image ID: %s\n", imgID)
+
+		for _, repoTag := range w.m.RepoTags {
+			named, err := reference.ParseNormalizedNamed(repoTag)
+			if err != nil {
+				return true, err
+			}
+			ref, ok := named.(reference.NamedTagged)
+			if !ok {
+				return true, fmt.Errorf("invalid tag %q", repoTag)
+			}
+			l.setLoadedTag(ref, imgID.Digest(), outStream)
+			outStream.Write([]byte(fmt.Sprintf("Loaded image: %s\n", reference.FamiliarString(ref))))
+			imageRefCount++
+		}
+
+		parentLinks = append(parentLinks, parentLink{imgID, w.m.Parent})
+		l.loggerImgEvent.LogImageEvent(imgID.String(), imgID.String(), "load")
+	}
+
+	for _, p := range validatedParentLinks(parentLinks) {
+		if p.parentID != "" {
+			if err := l.setParentID(p.id, p.parentID); err != nil {
+				return true, err
+			}
+		}
+	}
+
+	if imageRefCount == 0 {
+		outStream.Write([]byte(imageIDsStr))
+	}
+
+	return true, nil
+}
+
+// registerLayerFromReader decompresses r (a single layer tar, of the given
+// compressed size, read from the archive) and registers it with the layer
+// store under ref's parent chain.
+func (l *tarexporter) registerLayerFromReader(r io.Reader, size int64, ref *pendingLayer, progressOutput progress.Output) (layer.Layer, error) {
+	var body io.Reader = r
+	if progressOutput != nil {
+		body = progress.NewProgressReader(ioutil.NopCloser(r), progressOutput, size, stringid.TruncateID(ref.diffID.String()), "Loading layer")
+	}
+	inflated, err := decompressLayerStream(body)
+	if err != nil {
+		return nil, err
+	}
+	defer inflated.Close()
+
+	var newLayer layer.Layer
+	if ds, ok := l.ls.(layer.DescribableStore); ok {
+		newLayer, err = ds.RegisterWithDescriptor(inflated, ref.rootFS.ChainID(), ref.source)
+	} else {
+		newLayer, err = l.ls.Register(inflated, ref.rootFS.ChainID())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expected, actual := ref.diffID, newLayer.DiffID(); expected != actual {
+		return nil, fmt.Errorf("invalid diffID for layer %q: expected %q, got %q", ref.path, expected, actual)
+	}
+	return newLayer, nil
+}
+
+// spoolTarEntry copies the remainder of the current tar entry to a temp
+// file, so a physical blob shared by more than one pendingLayer can be
+// decompressed and registered once per parent chain without re-reading the
+// single-pass archive.
+func spoolTarEntry(r io.Reader) (string, error) {
+	f, err := ioutil.TempFile("", "docker-layer-spool-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// scanTarMetadata performs a first pass over a tar stream, keeping
+// manifest.json and every *.json config blob in memory while skipping over
+// (without reading) layer tar entries. found is false if no manifest.json
+// entry is present, signaling a format the streaming path doesn't handle.
+func scanTarMetadata(r io.Reader) (manifest []manifestItem, jsonFiles map[string][]byte, found bool, err error) {
+	jsonFiles = make(map[string][]byte)
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		name := strings.TrimPrefix(hdr.Name, "./")
+		if strings.HasSuffix(name, ".json") {
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, nil, false, err
+			}
+			jsonFiles[name] = data
+		}
+	}
+
+	data, ok := jsonFiles[manifestFileName]
+	if !ok {
+		return nil, nil, false, nil
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, nil, false, err
+	}
+	return manifest, jsonFiles, true, nil
+}
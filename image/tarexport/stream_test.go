@@ -0,0 +1,83 @@
+package tarexport
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func buildTar(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestScanTarMetadataFindsManifest(t *testing.T) {
+	manifestJSON := `[{"Config":"abc.json","RepoTags":["img:latest"],"Layers":["layer1/layer.tar"]}]`
+	data := buildTar(t, map[string]string{
+		manifestFileName:   manifestJSON,
+		"abc.json":         `{"hello":"config"}`,
+		"layer1/layer.tar": "not json, skipped by the scan",
+	})
+
+	manifest, jsonFiles, found, err := scanTarMetadata(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("scanTarMetadata: %v", err)
+	}
+	if !found {
+		t.Fatal("expected found=true when manifest.json is present")
+	}
+	if len(manifest) != 1 || manifest[0].Config != "abc.json" {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+	if _, ok := jsonFiles["abc.json"]; !ok {
+		t.Fatal("expected abc.json to be captured")
+	}
+	if _, ok := jsonFiles["layer1/layer.tar"]; ok {
+		t.Fatal("expected non-.json layer entry not to be captured")
+	}
+}
+
+func TestScanTarMetadataReportsNotFoundWithoutManifest(t *testing.T) {
+	data := buildTar(t, map[string]string{"repositories": "{}"})
+
+	_, _, found, err := scanTarMetadata(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("scanTarMetadata: %v", err)
+	}
+	if found {
+		t.Fatal("expected found=false for an archive without manifest.json")
+	}
+}
+
+func TestSpoolTarEntryCopiesRemainingBytes(t *testing.T) {
+	want := "the rest of this tar entry's bytes"
+	path, err := spoolTarEntry(bytes.NewReader([]byte(want)))
+	if err != nil {
+		t.Fatalf("spoolTarEntry: %v", err)
+	}
+	defer os.Remove(path)
+
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Fatalf("spooled content = %q, want %q", got, want)
+	}
+}
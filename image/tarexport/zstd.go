@@ -0,0 +1,51 @@
+package tarexport
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdMagic is the 4-byte frame magic number identifying a zstd-compressed
+// stream (RFC 8478).
+var zstdMagic = []byte{0x28, 0xB5, 0x2F, 0xFD}
+
+// decompressLayerStream inflates a layer tar stream, recognizing zstd in
+// addition to the gzip/xz/bzip2 formats already handled by
+// archive.DecompressStream. zstd isn't detected by that function, so its
+// magic is peeked for here first and the remainder of the stream is routed
+// to a streaming zstd decoder; everything else falls through unchanged.
+func decompressLayerStream(r io.Reader) (io.ReadCloser, error) {
+	buf := bufio.NewReaderSize(r, 4)
+	magic, err := buf.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if len(magic) == 4 && string(magic) == string(zstdMagic) {
+		dec, err := zstd.NewReader(buf)
+		if err != nil {
+			return nil, err
+		}
+		return &zstdReadCloser{dec: dec}, nil
+	}
+
+	return archive.DecompressStream(buf)
+}
+
+// zstdReadCloser adapts a *zstd.Decoder, whose Close method doesn't return
+// an error, to the io.ReadCloser the layer registration pipeline expects.
+type zstdReadCloser struct {
+	dec *zstd.Decoder
+}
+
+func (z *zstdReadCloser) Read(p []byte) (int, error) {
+	return z.dec.Read(p)
+}
+
+func (z *zstdReadCloser) Close() error {
+	z.dec.Close()
+	return nil
+}
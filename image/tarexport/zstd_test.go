@@ -0,0 +1,59 @@
+package tarexport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDecompressLayerStreamZstd(t *testing.T) {
+	want := []byte("hello layer contents")
+
+	var buf bytes.Buffer
+	enc, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.Write(want); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := decompressLayerStream(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("decompressLayerStream: %v", err)
+	}
+	defer rc.Close()
+
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("decompressed = %q, want %q", got, want)
+	}
+}
+
+func TestDecompressLayerStreamFallsThroughForNonZstd(t *testing.T) {
+	// a plain uncompressed stream should be returned unchanged by the
+	// fallback to archive.DecompressStream rather than mistaken for zstd.
+	want := []byte("not compressed at all")
+
+	rc, err := decompressLayerStream(bytes.NewReader(want))
+	if err != nil {
+		t.Fatalf("decompressLayerStream: %v", err)
+	}
+	defer rc.Close()
+
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("passthrough content = %q, want %q", got, want)
+	}
+}